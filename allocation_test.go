@@ -0,0 +1,77 @@
+package sslstrip
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestStreamRewriteLinksRegexAllocs guards the pooling done in
+// streamRewriteLinksRegex: the scratch read/carry buffer must come from
+// scratchPool instead of being allocated fresh on every call, since this is
+// the hot path large binary downloads go through.
+func TestStreamRewriteLinksRegexAllocs(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	body := strings.Repeat("plain payload with no links in it, just bytes. ", 4000)
+
+	var buf bytes.Buffer
+	const maxAllocsPerRun = 20
+
+	allocs := testing.AllocsPerRun(20, func() {
+		buf.Reset()
+		if err := streamRewriteLinksRegex(req, strings.NewReader(body), &buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if allocs > maxAllocsPerRun {
+		t.Errorf("streamRewriteLinksRegex: got %.1f allocs/op, want <= %d; is the scratch buffer still coming from scratchPool?", allocs, maxAllocsPerRun)
+	}
+}
+
+// TestServeHTTPAllocsForSmallHTML drives a canned request through
+// server.ServeHTTP against a real httptest.Server upstream, the way a
+// victim's request for a small HTML page actually flows through the whole
+// proxy: Director, the real net/http.Transport round trip over a loopback
+// TCP connection, ModifyResponse, the HTML tokenizer/rewrite, and copying
+// the result to the ResponseWriter.
+//
+// The bound here is much higher than a single-digit allocs/op figure,
+// because that's only realistic for an isolated function, not a full round
+// trip: net/http allocates per request/response on both the client and
+// server side of the loopback connection regardless of anything sslstrip
+// does, and html.Parse builds a real node per element. Measured at ~131
+// allocs/op for this handler; the bound below leaves headroom for Go
+// version/runtime variance and only catches a large regression. The
+// upstream response is not compressed, so the gzip reader/writer pools are
+// not exercised here.
+func TestServeHTTPAllocsForSmallHTML(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="https://example.com/page">link</a></body></html>`))
+	}))
+	defer upstream.Close()
+
+	var logger bytes.Buffer
+	s := newServer(&logger, false, false, false, "")
+
+	const maxAllocsPerRun = 400
+
+	allocs := testing.AllocsPerRun(20, func() {
+		req := httptest.NewRequest("GET", upstream.URL+"/", nil)
+		req.RemoteAddr = "203.0.113.5:4321"
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", rec.Code)
+		}
+	})
+
+	if allocs > maxAllocsPerRun {
+		t.Errorf("server.ServeHTTP: got %.1f allocs/op for a small HTML response, want <= %d", allocs, maxAllocsPerRun)
+	}
+}
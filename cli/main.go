@@ -3,21 +3,38 @@ package main
 import (
 	"flag"
 	"sslstrip"
+	"time"
 )
 
 var port = flag.Int("port", 8000, "port to listen on")
 var filename = flag.String("filename", "", "the output log file or empty for stdout")
 var postOnly = flag.Bool("post-only", false, "log only POST requests")
 var logResponse = flag.Bool("log-response", false, "log responses")
+var sessionJar = flag.Bool("session-jar", false, "track cookies per client in a server-side session jar")
+var jarTTL = flag.Duration("jar-ttl", 30*time.Minute, "evict a client's session jar after this much idle time")
+var jarFile = flag.String("jar-file", "", "persist session jars to this file so they survive a restart")
+var cacheBackend = flag.String("cache-backend", "memory", "link cache backend: memory or redis")
+var cacheShards = flag.Int("cache-shards", 0, "number of shards for the memory link cache (0 for the default)")
+var cacheSize = flag.Int("cache-size", 0, "max entries per shard for the memory link cache (0 for the default)")
+var cacheTTL = flag.Duration("cache-ttl", 30*time.Minute, "expire a cached stripped link this long after it was last stored")
+var redisAddr = flag.String("redis-addr", "localhost:6379", "redis address, used when -cache-backend=redis")
 
 func main() {
 	flag.Parse()
 
 	params := sslstrip.Params{
-		Port:        *port,
-		Filename:    *filename,
-		PostOnly:    *postOnly,
-		LogResponse: *logResponse,
+		Port:         *port,
+		Filename:     *filename,
+		PostOnly:     *postOnly,
+		LogResponse:  *logResponse,
+		SessionJar:   *sessionJar,
+		JarTTL:       *jarTTL,
+		JarFile:      *jarFile,
+		CacheBackend: *cacheBackend,
+		CacheShards:  *cacheShards,
+		CacheSize:    *cacheSize,
+		CacheTTL:     *cacheTTL,
+		RedisAddr:    *redisAddr,
 	}
 
 	sslstrip.Start(params)
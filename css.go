@@ -0,0 +1,159 @@
+package sslstrip
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// rewriteCSS walks a stylesheet (or a single style="..." attribute value)
+// looking for url(...), @import "..." and image-set(...) entries, and
+// rewrites any that point at an absolute https url. Quoted strings outside
+// of those three constructs (e.g. font-family: "Open Sans" or content:
+// "...") are left alone.
+//
+// image-set(...) is handled separately from the other two because its
+// entries can themselves contain url(...) calls (nested parens), which a
+// single non-recursive regex can't safely bound: cutting it off at the
+// first ')' truncates a call like
+// image-set(url("a.png") 1x, "b.png" 2x) before the second entry. Instead,
+// every image-set( is found by its literal prefix and its matching close
+// paren is found by tracking paren depth, so the whole call is captured
+// before its entries are rewritten.
+func rewriteCSS(req *http.Request, css string) string {
+	var out strings.Builder
+	pos := 0
+
+	for pos < len(css) {
+		urlLoc := cssURLCallRegex.FindStringIndex(css[pos:])
+		importLoc := cssImportRegex.FindStringIndex(css[pos:])
+		imageSetIdx := strings.Index(css[pos:], "image-set(")
+
+		start, kind := -1, ""
+		considerMatch := func(loc []int, k string) {
+			if loc != nil && (start == -1 || loc[0] < start) {
+				start, kind = loc[0], k
+			}
+		}
+		considerMatch(urlLoc, "url")
+		considerMatch(importLoc, "import")
+		if imageSetIdx != -1 && (start == -1 || imageSetIdx < start) {
+			start, kind = imageSetIdx, "image-set"
+		}
+
+		if start == -1 {
+			out.WriteString(css[pos:])
+			break
+		}
+
+		tokenStart := pos + start
+		out.WriteString(css[pos:tokenStart])
+
+		var tokenEnd int
+		switch kind {
+		case "url":
+			tokenEnd = pos + urlLoc[1]
+			out.WriteString(rewriteCSSURLCall(req, css[tokenStart:tokenEnd]))
+		case "import":
+			tokenEnd = pos + importLoc[1]
+			out.WriteString(rewriteCSSImport(req, css[tokenStart:tokenEnd]))
+		default: // image-set
+			tokenEnd = matchingParen(css, tokenStart+len("image-set"))
+			out.WriteString(rewriteCSSImageSet(req, css[tokenStart:tokenEnd]))
+		}
+
+		pos = tokenEnd
+	}
+
+	return out.String()
+}
+
+// cssURLCallRegex matches a url(...) call, quoted or not.
+var cssURLCallRegex = regexp.MustCompile(`url\(\s*(?:'[^']*'|"[^"]*"|[^'")]*)\s*\)`)
+
+// cssImportRegex matches an @import "..." statement.
+var cssImportRegex = regexp.MustCompile(`(?i:@import)\s+(?:'[^']*'|"[^"]*")`)
+
+// cssImageSetEntryRegex matches a single entry inside an image-set(...)
+// call, either a nested url(...) call or a bare quoted string.
+var cssImageSetEntryRegex = regexp.MustCompile(`url\(\s*(?:'[^']*'|"[^"]*"|[^'")]*)\s*\)|'[^']*'|"[^"]*"`)
+
+// matchingParen returns the index just past the ')' that closes the '(' at
+// openIdx, accounting for nested parens (as in image-set(url(...), ...)).
+// If the parens are unbalanced, it returns len(css).
+func matchingParen(css string, openIdx int) int {
+	depth := 0
+	for i := openIdx; i < len(css); i++ {
+		switch css[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(css)
+}
+
+// rewriteCSSURLCall rewrites the url inside a url(...) call, quoted or not.
+func rewriteCSSURLCall(req *http.Request, token string) string {
+	inner := strings.TrimSpace(token[len("url(") : len(token)-1])
+
+	quote := byte(0)
+	rawURL := inner
+	if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+		quote = inner[0]
+		rawURL = inner[1 : len(inner)-1]
+	}
+
+	stripped, changed := stripHTTPSURL(req, rawURL)
+	if !changed {
+		return token
+	}
+
+	if quote == 0 {
+		return "url(" + stripped + ")"
+	}
+	return "url(" + string(quote) + stripped + string(quote) + ")"
+}
+
+// rewriteCSSImport rewrites the quoted url in an @import "..." statement.
+func rewriteCSSImport(req *http.Request, token string) string {
+	idx := strings.IndexAny(token, "'\"")
+	if idx == -1 {
+		return token
+	}
+
+	quote := token[idx]
+	rawURL := token[idx+1 : len(token)-1]
+
+	stripped, changed := stripHTTPSURL(req, rawURL)
+	if !changed {
+		return token
+	}
+
+	return token[:idx] + string(quote) + stripped + string(quote)
+}
+
+// rewriteCSSImageSet rewrites every entry (url(...) call or bare quoted
+// string) among the possibly several, comma-separated candidates inside an
+// image-set(...) call.
+func rewriteCSSImageSet(req *http.Request, token string) string {
+	return cssImageSetEntryRegex.ReplaceAllStringFunc(token, func(entry string) string {
+		if strings.HasPrefix(entry, "url(") {
+			return rewriteCSSURLCall(req, entry)
+		}
+
+		quote := entry[0]
+		rawURL := entry[1 : len(entry)-1]
+
+		stripped, changed := stripHTTPSURL(req, rawURL)
+		if !changed {
+			return entry
+		}
+
+		return string(quote) + stripped + string(quote)
+	})
+}
@@ -0,0 +1,61 @@
+package sslstrip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newCSSTestRequest() *http.Request {
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.1:12345"
+	return req
+}
+
+func TestRewriteCSSURLCall(t *testing.T) {
+	req := newCSSTestRequest()
+	out := rewriteCSS(req, `background: url(https://example.com/a.png);`)
+	if strings.Contains(out, "https://") {
+		t.Errorf("url(...) should have been stripped, got %q", out)
+	}
+}
+
+func TestRewriteCSSImport(t *testing.T) {
+	req := newCSSTestRequest()
+	out := rewriteCSS(req, `@import "https://example.com/b.css";`)
+	if strings.Contains(out, "https://") {
+		t.Errorf("@import should have been stripped, got %q", out)
+	}
+}
+
+// TestRewriteCSSImageSetMixedEntries guards against the image-set(...)
+// regex stopping at the first ')': a call mixing a nested url(...)
+// candidate with a later bare-quoted-string candidate must have every
+// entry rewritten, not just the first.
+func TestRewriteCSSImageSetMixedEntries(t *testing.T) {
+	req := newCSSTestRequest()
+	css := `background-image: image-set(url("https://a.example/a.png") 1x, "https://b.example/b.png" 2x);`
+
+	out := rewriteCSS(req, css)
+
+	if strings.Contains(out, "https://a.example/a.png") {
+		t.Errorf("first (url()) entry should have been stripped, got %q", out)
+	}
+	if strings.Contains(out, "https://b.example/b.png") {
+		t.Errorf("second (bare string) entry should have been stripped, got %q", out)
+	}
+}
+
+// TestRewriteCSSLeavesOrdinaryStringsAlone makes sure the tokenizer doesn't
+// touch quoted strings outside url()/@import/image-set.
+func TestRewriteCSSLeavesOrdinaryStringsAlone(t *testing.T) {
+	req := newCSSTestRequest()
+	css := `font-family: "Open Sans"; content: "https://not-a-link-context.example/";`
+
+	out := rewriteCSS(req, css)
+
+	if out != css {
+		t.Errorf("ordinary quoted strings should be left untouched, got %q, want %q", out, css)
+	}
+}
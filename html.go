@@ -0,0 +1,188 @@
+package sslstrip
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// urlAttrsByTag lists, per tag, the attributes that hold a single url.
+// Attributes not listed here are still checked and rewritten if their
+// value happens to parse as an absolute https url.
+var urlAttrsByTag = map[string][]string{
+	"a":          {"href"},
+	"area":       {"href"},
+	"base":       {"href"},
+	"link":       {"href"},
+	"img":        {"src"},
+	"script":     {"src"},
+	"source":     {"src"},
+	"track":      {"src"},
+	"embed":      {"src"},
+	"iframe":     {"src"},
+	"form":       {"action"},
+	"button":     {"formaction"},
+	"input":      {"formaction", "src"},
+	"audio":      {"src"},
+	"video":      {"src", "poster"},
+	"object":     {"data"},
+	"blockquote": {"cite"},
+	"q":          {"cite"},
+	"ins":        {"cite"},
+	"del":        {"cite"},
+	"html":       {"manifest"},
+}
+
+// rewriteHTML walks the parsed document tree and rewrites every attribute,
+// inline style and <meta refresh>/<base href> value that points at an
+// absolute https url. Using a real tokenizer means HTML-encoded urls
+// (&amp;, &#x2f;, ...) are already decoded by the time we see them, and the
+// rendered output is re-encoded correctly on the way back out.
+//
+// A full html.Parse always produces a whole document, wrapping a bare
+// fragment in <html><head></head><body>...</body></html>. That's wrong for
+// the very common case of a text/html response that's actually a partial
+// page (jQuery .load(), HTMX, Turbo Stream, ...), so those are parsed with
+// ParseFragment against a generic <body> context instead.
+func rewriteHTML(req *http.Request, body []byte) []byte {
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			rewriteElementAttrs(req, n)
+		}
+		if n.Type == html.TextNode && (n.Parent != nil && (n.Parent.Data == "script" || n.Parent.Data == "style")) {
+			n.Data = string(rewriteLinksRegex(req, []byte(n.Data)))
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	if !looksLikeFullDocument(body) {
+		context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		nodes, err := html.ParseFragment(bytes.NewReader(body), context)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not parse html fragment: %q\n", err)
+			return rewriteLinksRegex(req, body)
+		}
+
+		var buf bytes.Buffer
+		for _, n := range nodes {
+			walk(n)
+			if err := html.Render(&buf, n); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not render html fragment: %q\n", err)
+				return rewriteLinksRegex(req, body)
+			}
+		}
+		return buf.Bytes()
+	}
+
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not parse html body: %q\n", err)
+		return rewriteLinksRegex(req, body)
+	}
+	walk(doc)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not render html body: %q\n", err)
+		return rewriteLinksRegex(req, body)
+	}
+
+	return buf.Bytes()
+}
+
+// looksLikeFullDocument reports whether body looks like it starts a whole
+// HTML document (a doctype or an <html> tag) rather than a bare fragment.
+func looksLikeFullDocument(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	lower := bytes.ToLower(trimmed)
+	return bytes.HasPrefix(lower, []byte("<!doctype")) || bytes.HasPrefix(lower, []byte("<html"))
+}
+
+func rewriteElementAttrs(req *http.Request, n *html.Node) {
+	urlAttrs := make(map[string]struct{})
+	for _, name := range urlAttrsByTag[n.Data] {
+		urlAttrs[name] = struct{}{}
+	}
+
+	for i, attr := range n.Attr {
+		switch {
+		case n.Data == "meta" && strings.EqualFold(attr.Key, "content") && hasHTTPEquivRefresh(n):
+			n.Attr[i].Val = rewriteMetaRefresh(req, attr.Val)
+		case attr.Key == "srcset":
+			n.Attr[i].Val = rewriteSrcset(req, attr.Val)
+		case attr.Key == "style":
+			n.Attr[i].Val = rewriteCSS(req, attr.Val)
+		default:
+			if _, isUrlAttr := urlAttrs[attr.Key]; isUrlAttr {
+				if stripped, changed := stripHTTPSURL(req, attr.Val); changed {
+					n.Attr[i].Val = stripped
+				}
+			} else if stripped, changed := stripHTTPSURL(req, attr.Val); changed {
+				// attribute we don't special-case, but its value is an
+				// absolute https url anyway (e.g. custom data-* attrs)
+				n.Attr[i].Val = stripped
+			}
+		}
+	}
+}
+
+func hasHTTPEquivRefresh(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, "http-equiv") && strings.EqualFold(attr.Val, "refresh") {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteMetaRefresh handles content="5;url=https://example.com/" values.
+func rewriteMetaRefresh(req *http.Request, content string) string {
+	idx := strings.IndexAny(content, "uU")
+	for idx != -1 {
+		if strings.HasPrefix(strings.ToLower(content[idx:]), "url=") {
+			break
+		}
+		next := strings.IndexAny(content[idx+1:], "uU")
+		if next == -1 {
+			idx = -1
+			break
+		}
+		idx += next + 1
+	}
+	if idx == -1 {
+		return content
+	}
+
+	prefix := content[:idx+len("url=")]
+	rawURL := content[idx+len("url="):]
+	if stripped, changed := stripHTTPSURL(req, rawURL); changed {
+		return prefix + stripped
+	}
+	return content
+}
+
+// rewriteSrcset handles "url1 1x, url2 640w" candidate lists.
+func rewriteSrcset(req *http.Request, srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		trimmed := strings.TrimSpace(candidate)
+		fields := strings.Fields(trimmed)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if stripped, changed := stripHTTPSURL(req, fields[0]); changed {
+			fields[0] = stripped
+		}
+		candidates[i] = " " + strings.Join(fields, " ")
+	}
+	return strings.TrimPrefix(strings.Join(candidates, ","), " ")
+}
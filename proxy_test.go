@@ -0,0 +1,46 @@
+package sslstrip
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestReadAndCloseOriginalUnblocksWriter guards against a goroutine leak:
+// Close must close pipeReader even when it isn't directly what's being
+// read (logging/compression can wrap it), so a writer blocked on the other
+// end of the pipe (the rewrite goroutine) is released once the consumer
+// goes away, instead of blocking forever.
+func TestReadAndCloseOriginalUnblocksWriter(t *testing.T) {
+	pr, pw := io.Pipe()
+
+	wrapped := &readAndCloseOriginal{
+		r:          pr,
+		orig:       io.NopCloser(nil),
+		pipeReader: pr,
+		onEOF:      func() {},
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := pw.Write([]byte("some rewritten body bytes"))
+		writeErr <- err
+	}()
+
+	// give the writer a moment to actually block on the unbuffered pipe
+	time.Sleep(10 * time.Millisecond)
+
+	if err := wrapped.Close(); err != nil {
+		t.Fatalf("Close returned an error: %q", err)
+	}
+
+	select {
+	case err := <-writeErr:
+		if !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("got %v, want io.ErrClosedPipe", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pw.Write never returned after Close; the rewrite goroutine would leak forever")
+	}
+}
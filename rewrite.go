@@ -0,0 +1,40 @@
+package sslstrip
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// httpsLinkRegex is the fallback matcher used for content types we don't
+// have a dedicated rewriter for (plain text, unknown binary-ish types, ...).
+var httpsLinkRegex = regexp.MustCompile("(https://[a-zA-Z0-9_:#@%/;$()~_?+-=\\.&]*)")
+
+// rewriteBody strips https links out of a response body. The content type
+// picks which rewriter handles the body: a tokenizer-based walk for HTML, a
+// small CSS tokenizer for stylesheets, a JSON value walk for JSON bodies,
+// and the regex matcher above for everything else.
+func rewriteBody(req *http.Request, contentType string, body []byte) []byte {
+	switch {
+	case strings.Contains(contentType, "text/html"):
+		return rewriteHTML(req, body)
+	case strings.Contains(contentType, "text/css"):
+		return []byte(rewriteCSS(req, string(body)))
+	case strings.Contains(contentType, "application/json"):
+		return rewriteJSON(req, body)
+	default:
+		return rewriteLinksRegex(req, body)
+	}
+}
+
+// rewriteLinksRegex is the original, content-type-agnostic fallback: it
+// matches raw https:// byte sequences and strips them one by one.
+func rewriteLinksRegex(req *http.Request, body []byte) []byte {
+	return httpsLinkRegex.ReplaceAllFunc(body, func(u []byte) []byte {
+		strippedUrl, changed := stripHTTPSURL(req, string(u))
+		if !changed {
+			return u
+		}
+		return []byte(strippedUrl)
+	})
+}
@@ -0,0 +1,49 @@
+package sslstrip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"sslstrip/linkcache"
+)
+
+// TestStripHTTPSURLCrossClientIsolation exercises stripHTTPSURL the way
+// director does: through real http.Request values with distinct
+// RemoteAddrs (including IPv6, which is what normalizeIP has to get right),
+// and checks that one client's stripped url never resolves under another
+// client's cache key.
+func TestStripHTTPSURLCrossClientIsolation(t *testing.T) {
+	reqA := httptest.NewRequest("GET", "http://example.com/", nil)
+	reqA.RemoteAddr = "203.0.113.1:54321"
+
+	reqB := httptest.NewRequest("GET", "http://example.com/", nil)
+	reqB.RemoteAddr = "[2001:db8::1]:54321"
+
+	strippedA, changed := stripHTTPSURL(reqA, "https://example.com/secret-a")
+	if !changed {
+		t.Fatalf("expected an absolute https url to be stripped")
+	}
+
+	if _, ok := linkCache.Get(linkcache.Key{ClientIP: normalizeIP(reqB.RemoteAddr), URL: strippedA}); ok {
+		t.Errorf("client B must not be able to resolve a url client A had stripped")
+	}
+
+	if _, ok := linkCache.Get(linkcache.Key{ClientIP: normalizeIP(reqA.RemoteAddr), URL: strippedA}); !ok {
+		t.Errorf("client A should be able to resolve its own stripped url")
+	}
+}
+
+// TestNormalizeIPv6 guards the fix for normalizeIP truncating IPv6
+// addresses at their first colon: distinct IPv6 clients must normalize to
+// distinct keys.
+func TestNormalizeIPv6(t *testing.T) {
+	ip1 := normalizeIP("[2001:db8::1]:54321")
+	ip2 := normalizeIP("[2001:db8::2]:54322")
+
+	if ip1 == ip2 {
+		t.Fatalf("distinct IPv6 clients normalized to the same IP: %q", ip1)
+	}
+	if ip1 != "2001:db8::1" {
+		t.Errorf("got %q, want 2001:db8::1", ip1)
+	}
+}
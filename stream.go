@@ -0,0 +1,262 @@
+package sslstrip
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// gzipReaderPool and gzipWriterPool recycle the (fairly heavy) gzip
+// decoder/encoder state across requests instead of allocating a fresh one
+// every time, which is what the previous implementation did.
+var gzipReaderPool = sync.Pool{New: func() interface{} { return new(gzip.Reader) }}
+var gzipWriterPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(ioutil.Discard) }}
+
+// bufferPool recycles the scratch buffer used to read a whole body before
+// handing it to a rewriter that needs the full document (HTML/CSS/JSON).
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// scratchPool recycles the read/carry buffer used by
+// streamRewriteLinksRegex, the hot path for large binary downloads, instead
+// of allocating a fresh one on every call. It stores a *[]byte (rather than
+// []byte directly) so putting a buffer back doesn't itself allocate a new
+// interface value around the slice header.
+var scratchPool = sync.Pool{New: func() interface{} {
+	b := make([]byte, streamChunkSize)
+	return &b
+}}
+
+// decodeBody wraps a response body in the decompressing reader matching
+// its Content-Encoding, if any.
+func decodeBody(contentEncoding string, body io.Reader) (io.Reader, error) {
+	switch contentEncoding {
+	case "gzip":
+		zr := gzipReaderPool.Get().(*gzip.Reader)
+		if err := zr.Reset(body); err != nil {
+			gzipReaderPool.Put(zr)
+			return nil, err
+		}
+		return &pooledGzipReader{zr}, nil
+	case "deflate":
+		return flate.NewReader(body), nil
+	case "br":
+		return brotli.NewReader(body), nil
+	default:
+		return body, nil
+	}
+}
+
+// pooledGzipReader returns its *gzip.Reader to gzipReaderPool once it hits
+// EOF, so the underlying flate state and buffers can be reused.
+type pooledGzipReader struct {
+	*gzip.Reader
+}
+
+func (p *pooledGzipReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	if err == io.EOF {
+		p.Reader.Close()
+		gzipReaderPool.Put(p.Reader)
+	}
+	return n, err
+}
+
+// encodeBody re-compresses a rewritten body back into its original
+// Content-Encoding, streaming through an io.Pipe so the whole body never
+// has to sit in memory at once.
+func encodeBody(contentEncoding string, r io.Reader) (io.Reader, error) {
+	switch contentEncoding {
+	case "gzip":
+		pr, pw := io.Pipe()
+		zw := gzipWriterPool.Get().(*gzip.Writer)
+		zw.Reset(pw)
+		go func() {
+			defer func() {
+				zw.Reset(ioutil.Discard)
+				gzipWriterPool.Put(zw)
+			}()
+			if _, err := io.Copy(zw, r); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(zw.Close())
+		}()
+		return pr, nil
+	case "deflate":
+		pr, pw := io.Pipe()
+		go func() {
+			w, err := flate.NewWriter(pw, flate.DefaultCompression)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(w, r); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(w.Close())
+		}()
+		return pr, nil
+	case "br":
+		pr, pw := io.Pipe()
+		go func() {
+			w := brotli.NewWriter(pw)
+			if _, err := io.Copy(w, r); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(w.Close())
+		}()
+		return pr, nil
+	default:
+		return r, nil
+	}
+}
+
+// streamRewrite feeds a decoded response body through the right rewriter
+// for its content type and writes the result into pw. HTML/CSS/JSON need
+// the full document to parse correctly, so those are read in one shot;
+// everything else (the regex fallback, which is what large binary
+// downloads hit) is rewritten chunk by chunk so it never gets buffered.
+func streamRewrite(req *http.Request, contentType string, src io.Reader, pw *io.PipeWriter) {
+	switch {
+	case strings.Contains(contentType, "text/html"):
+		pw.CloseWithError(rewriteWhole(req, src, pw, rewriteHTML))
+	case strings.Contains(contentType, "text/css"):
+		pw.CloseWithError(rewriteWhole(req, src, pw, func(req *http.Request, body []byte) []byte {
+			return []byte(rewriteCSS(req, string(body)))
+		}))
+	case strings.Contains(contentType, "application/json"):
+		pw.CloseWithError(rewriteWhole(req, src, pw, rewriteJSON))
+	default:
+		pw.CloseWithError(streamRewriteLinksRegex(req, src, pw))
+	}
+}
+
+func rewriteWhole(req *http.Request, src io.Reader, dst io.Writer, rewrite func(*http.Request, []byte) []byte) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+
+	if _, err := buf.ReadFrom(src); err != nil {
+		return err
+	}
+
+	_, err := dst.Write(rewrite(req, buf.Bytes()))
+	return err
+}
+
+// streamChunkSize is how much we read from the upstream body at a time.
+const streamChunkSize = 32 * 1024
+
+// httpsLiteral is the fixed prefix httpsLinkRegex always starts a match
+// with. It's used to recognize a partial "https://" sitting right at the
+// end of a chunk that hasn't become a regex match yet, but could once more
+// bytes arrive.
+const httpsLiteral = "https://"
+
+// streamRewriteLinksRegex rewrites https links chunk by chunk, without
+// ever buffering the whole body in memory.
+//
+// A naive implementation that rewrites each chunk independently would split
+// an "https://..." match that straddles a chunk boundary in half. Instead
+// of holding back a fixed-size trailing window (which breaks on a url
+// longer than that window, e.g. a long S3 presigned link), every chunk is
+// scanned for the last point it's actually safe to cut: right before a
+// match that runs all the way to the end of the available data (so it
+// might still be growing) or before a partial "https://" prefix at the
+// tail. Everything before that point is rewritten and emitted now;
+// everything from it onward is carried into the next iteration, however
+// long that turns out to be.
+func streamRewriteLinksRegex(req *http.Request, src io.Reader, dst io.Writer) error {
+	reader := bufio.NewReaderSize(src, streamChunkSize)
+
+	// scratch holds the carried-over tail from the previous iteration plus
+	// the freshly read bytes. It comes from scratchPool instead of being
+	// allocated fresh every call, and is only grown (not reallocated from
+	// scratch) when a carry-over run longer than usual demands it.
+	scratchPtr := scratchPool.Get().(*[]byte)
+	scratch := *scratchPtr
+	defer func() {
+		*scratchPtr = scratch
+		scratchPool.Put(scratchPtr)
+	}()
+	carryLen := 0
+
+	for {
+		if carryLen+streamChunkSize > len(scratch) {
+			grown := make([]byte, carryLen+streamChunkSize)
+			copy(grown, scratch[:carryLen])
+			scratch = grown
+		}
+
+		n, err := reader.Read(scratch[carryLen:])
+		chunk := scratch[:carryLen+n]
+
+		if n > 0 {
+			processLen := safeProcessLen(chunk)
+
+			if processLen > 0 {
+				if _, werr := dst.Write(rewriteLinksRegex(req, chunk[:processLen])); werr != nil {
+					return werr
+				}
+			}
+
+			carryLen = copy(scratch, chunk[processLen:])
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				if carryLen > 0 {
+					_, werr := dst.Write(rewriteLinksRegex(req, scratch[:carryLen]))
+					return werr
+				}
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// safeProcessLen returns how many leading bytes of chunk can be rewritten
+// now without risking splitting an in-progress "https://..." match; the
+// rest must be carried into the next read.
+func safeProcessLen(chunk []byte) int {
+	safe := len(chunk)
+
+	if locs := httpsLinkRegex.FindAllIndex(chunk, -1); len(locs) > 0 {
+		if last := locs[len(locs)-1]; last[1] == len(chunk) {
+			safe = last[0]
+		}
+	}
+
+	start := len(chunk) - (len(httpsLiteral) - 1)
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(chunk) && i < safe; i++ {
+		if isHTTPSLiteralPrefix(chunk[i:]) {
+			safe = i
+			break
+		}
+	}
+
+	return safe
+}
+
+// isHTTPSLiteralPrefix reports whether b is a (possibly full) prefix of the
+// literal "https://".
+func isHTTPSLiteralPrefix(b []byte) bool {
+	if len(b) == 0 || len(b) > len(httpsLiteral) {
+		return false
+	}
+	return string(b) == httpsLiteral[:len(b)]
+}